@@ -0,0 +1,197 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+// AccessModeError reports that a PVC backing one of a VMI's volumes does not satisfy
+// the access mode invariants required by ValidateVolumeAccessModes. It carries enough
+// detail for the VMI admission webhook to surface an actionable message, rather than
+// letting an opaque failure surface later at pod-creation time.
+type AccessModeError struct {
+	VolumeName    string
+	ClaimName     string
+	ActualModes   []k8sv1.PersistentVolumeAccessMode
+	RequiredModes []k8sv1.PersistentVolumeAccessMode
+	Reason        string
+}
+
+func (e *AccessModeError) Error() string {
+	return fmt.Sprintf("volume %s (claim %s): %s (has %v, needs %v)", e.VolumeName, e.ClaimName, e.Reason, e.ActualModes, e.RequiredModes)
+}
+
+// sharedBlockDeviceAnnotation lets a PVC explicitly opt a block device into being
+// treated as shareable across nodes even when its access mode doesn't literally say
+// ReadWriteMany/ReadOnlyMany, matching the annotation some CSI drivers and storage
+// operators (e.g. for SAN-backed block devices) use to advertise multi-attach support.
+const sharedBlockDeviceAnnotation = "volume.kubernetes.io/shareable"
+
+// ValidateVolumeAccessModes enforces KubeVirt's access-mode invariants across the full
+// set of volumes backing a VMI:
+//   - if migratable is true, every PVC must support ReadWriteMany (or be a shared block
+//     device, since block devices can be safely shared without a shared filesystem);
+//   - a PVC that is only ReadWriteOnce must not already be mounted by another pod (other
+//     than this VMI's own launcher pod, ownerPodName);
+//   - for block-mode PVCs, the CSI driver backing the StorageClass must actually
+//     advertise support for the Persistent volume lifecycle mode via its CSIDriver's
+//     volumeLifecycleModes.
+//
+// This replaces ad-hoc HasSharedAccessMode checks scattered across callers with a
+// single validation pass that returns a structured *AccessModeError.
+func ValidateVolumeAccessModes(volumes []*virtv1.Volume, pvcStore cache.Store, podStore cache.Store, csiDriverStore cache.Store, namespace string, ownerPodName string, migratable bool) error {
+	for _, volume := range volumes {
+		claimName := PVCNameFromVirtVolume(volume)
+		if claimName == "" {
+			return fmt.Errorf("volume %s is not a PVC or DataVolume", volume.Name)
+		}
+
+		pvc, exists, isBlock, err := IsPVCBlockFromStore(pvcStore, namespace, claimName)
+		if err != nil {
+			return fmt.Errorf("failed to get PVC: %v", err)
+		}
+		if !exists {
+			return fmt.Errorf("claim %s not found", claimName)
+		}
+
+		if migratable && !HasSharedAccessMode(pvc.Spec.AccessModes) && !isSharedBlockDevice(pvc, isBlock) {
+			return &AccessModeError{
+				VolumeName:    volume.Name,
+				ClaimName:     claimName,
+				ActualModes:   pvc.Spec.AccessModes,
+				RequiredModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+				Reason:        "VMI is migratable but claim does not support ReadWriteMany and is not a shared block device",
+			}
+		}
+
+		if !HasSharedAccessMode(pvc.Spec.AccessModes) && pvc.Status.Phase == k8sv1.ClaimBound &&
+			isMountedElsewhere(pvc, podStore, namespace, ownerPodName) {
+			return &AccessModeError{
+				VolumeName:    volume.Name,
+				ClaimName:     claimName,
+				ActualModes:   pvc.Spec.AccessModes,
+				RequiredModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+				Reason:        "claim is ReadWriteOnce and already mounted by another pod",
+			}
+		}
+
+		if isBlock {
+			if err := validateBlockDeviceIsPersistentVolumeLifecycle(pvc, csiDriverStore); err != nil {
+				if accessModeErr, ok := err.(*AccessModeError); ok {
+					accessModeErr.VolumeName = volume.Name
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isSharedBlockDevice reports whether a block-mode PVC can be safely attached to more
+// than one node at once, either because its access mode already says so or because it
+// carries an explicit shareable annotation.
+func isSharedBlockDevice(pvc *k8sv1.PersistentVolumeClaim, isBlock bool) bool {
+	if !isBlock {
+		return false
+	}
+	for _, mode := range pvc.Spec.AccessModes {
+		if mode == k8sv1.ReadWriteMany || mode == k8sv1.ReadOnlyMany {
+			return true
+		}
+	}
+	return pvc.Annotations[sharedBlockDeviceAnnotation] == "true"
+}
+
+// isMountedElsewhere reports whether the PVC is actively mounted by some pod in its
+// namespace other than ownerPodName (the VMI's own virt-launcher pod). The
+// kubernetes.io/pvc-protection finalizer alone can't answer this: it is attached for
+// the PVC's entire in-use lifetime, including by the VMI's own launcher, so it would
+// otherwise report every running VM's RWO volumes as "mounted elsewhere" on every call.
+func isMountedElsewhere(pvc *k8sv1.PersistentVolumeClaim, podStore cache.Store, namespace string, ownerPodName string) bool {
+	for _, obj := range podStore.List() {
+		pod, ok := obj.(*k8sv1.Pod)
+		if !ok || pod.Namespace != namespace || pod.Name == ownerPodName {
+			continue
+		}
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == k8sv1.PodSucceeded || pod.Status.Phase == k8sv1.PodFailed {
+			continue
+		}
+
+		for _, podVolume := range pod.Spec.Volumes {
+			if podVolume.PersistentVolumeClaim != nil && podVolume.PersistentVolumeClaim.ClaimName == pvc.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateBlockDeviceIsPersistentVolumeLifecycle checks that the CSI driver backing a
+// block-mode PVC's StorageClass actually declares support for the Persistent volume
+// lifecycle mode via CSIDriver.Spec.VolumeLifecycleModes. This is a check on how the
+// volume is provisioned (persistent vs. ephemeral inline volumes), not on the PVC's
+// ReadWriteOnce/ReadWriteMany/ReadOnlyMany access mode: CSIDriver has no field
+// declaring which access modes a driver supports, so there is no upstream signal to
+// validate that against directly. A CSIDriver object is optional, so a missing entry is
+// not treated as a validation failure — only an explicit, non-matching declaration is.
+func validateBlockDeviceIsPersistentVolumeLifecycle(pvc *k8sv1.PersistentVolumeClaim, csiDriverStore cache.Store) error {
+	provisioner, found := pvc.Annotations["volume.kubernetes.io/storage-provisioner"]
+	if !found {
+		return nil
+	}
+
+	obj, exists, err := csiDriverStore.GetByKey(provisioner)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	driver, ok := obj.(*storagev1.CSIDriver)
+	if !ok {
+		return fmt.Errorf("failed converting %s to a CSIDriver: object is of type %T", provisioner, obj)
+	}
+	if len(driver.Spec.VolumeLifecycleModes) == 0 {
+		return nil
+	}
+
+	for _, mode := range driver.Spec.VolumeLifecycleModes {
+		if mode == storagev1.VolumeLifecyclePersistent {
+			return nil
+		}
+	}
+
+	return &AccessModeError{
+		VolumeName:    pvc.Name,
+		ClaimName:     pvc.Name,
+		ActualModes:   pvc.Spec.AccessModes,
+		RequiredModes: nil,
+		Reason:        fmt.Sprintf("CSI driver %s does not support the %s volume lifecycle mode required for block devices", provisioner, storagev1.VolumeLifecyclePersistent),
+	}
+}
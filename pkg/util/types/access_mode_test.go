@@ -0,0 +1,186 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+func newBoundRWOPVC(name string) *k8sv1.PersistentVolumeClaim {
+	return &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       k8sv1.PersistentVolumeClaimSpec{AccessModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce}},
+		Status:     k8sv1.PersistentVolumeClaimStatus{Phase: k8sv1.ClaimBound},
+	}
+}
+
+func newPodMountingPVC(podName, claimName string) *k8sv1.Pod {
+	return &k8sv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: "default"},
+		Spec: k8sv1.PodSpec{
+			Volumes: []k8sv1.Volume{{
+				VolumeSource: k8sv1.VolumeSource{
+					PersistentVolumeClaim: &k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+				},
+			}},
+		},
+	}
+}
+
+func TestIsMountedElsewhereExcludesOwnLauncherPod(t *testing.T) {
+	pvc := newBoundRWOPVC("pvc")
+
+	t.Run("only the VMI's own launcher pod mounts it: not mounted elsewhere", func(t *testing.T) {
+		podStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+		_ = podStore.Add(newPodMountingPVC("virt-launcher-vm-abcde", "pvc"))
+
+		if isMountedElsewhere(pvc, podStore, "default", "virt-launcher-vm-abcde") {
+			t.Fatal("the VMI's own launcher pod must not count as mounting the PVC elsewhere")
+		}
+	})
+
+	t.Run("a different pod mounts it: mounted elsewhere", func(t *testing.T) {
+		podStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+		_ = podStore.Add(newPodMountingPVC("some-other-pod", "pvc"))
+
+		if !isMountedElsewhere(pvc, podStore, "default", "virt-launcher-vm-abcde") {
+			t.Fatal("expected a claim mounted by a different pod to be reported as mounted elsewhere")
+		}
+	})
+
+	t.Run("no pods mount it: not mounted elsewhere", func(t *testing.T) {
+		podStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+
+		if isMountedElsewhere(pvc, podStore, "default", "virt-launcher-vm-abcde") {
+			t.Fatal("expected no mounting pods to mean not mounted elsewhere")
+		}
+	})
+
+	t.Run("terminated pod referencing it doesn't count", func(t *testing.T) {
+		podStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+		terminated := newPodMountingPVC("finished-pod", "pvc")
+		terminated.Status.Phase = k8sv1.PodSucceeded
+		_ = podStore.Add(terminated)
+
+		if isMountedElsewhere(pvc, podStore, "default", "virt-launcher-vm-abcde") {
+			t.Fatal("a succeeded pod is not actively mounting the PVC")
+		}
+	})
+}
+
+func TestValidateVolumeAccessModesMigratableBlockDevice(t *testing.T) {
+	claimName := "block-pvc"
+	blockMode := k8sv1.PersistentVolumeBlock
+	volumes := []*virtv1.Volume{{
+		Name: "vol",
+		VolumeSource: virtv1.VolumeSource{
+			PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+				PersistentVolumeClaimVolumeSource: k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+			},
+		},
+	}}
+
+	t.Run("RWO block device is rejected for a migratable VMI", func(t *testing.T) {
+		pvcStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+		_ = pvcStore.Add(&k8sv1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: "default"},
+			Spec: k8sv1.PersistentVolumeClaimSpec{
+				AccessModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce},
+				VolumeMode:  &blockMode,
+			},
+		})
+		podStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+
+		err := ValidateVolumeAccessModes(volumes, pvcStore, podStore, cache.NewStore(cache.MetaNamespaceKeyFunc), "default", "virt-launcher-vm", true)
+		if err == nil {
+			t.Fatal("expected a non-shareable RWO block device to fail migratable validation")
+		}
+	})
+
+	t.Run("RWX block device passes for a migratable VMI", func(t *testing.T) {
+		pvcStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+		_ = pvcStore.Add(&k8sv1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: "default"},
+			Spec: k8sv1.PersistentVolumeClaimSpec{
+				AccessModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteMany},
+				VolumeMode:  &blockMode,
+			},
+		})
+		podStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+
+		err := ValidateVolumeAccessModes(volumes, pvcStore, podStore, cache.NewStore(cache.MetaNamespaceKeyFunc), "default", "virt-launcher-vm", true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestValidateBlockDeviceIsPersistentVolumeLifecycle(t *testing.T) {
+	provisioner := "csi.example.com"
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pvc",
+			Namespace:   "default",
+			Annotations: map[string]string{"volume.kubernetes.io/storage-provisioner": provisioner},
+		},
+	}
+
+	t.Run("no CSIDriver object: not a validation failure", func(t *testing.T) {
+		err := validateBlockDeviceIsPersistentVolumeLifecycle(pvc, cache.NewStore(cache.MetaNamespaceKeyFunc))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("CSIDriver declares Persistent lifecycle: passes", func(t *testing.T) {
+		csiDriverStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		_ = csiDriverStore.Add(&storagev1.CSIDriver{
+			ObjectMeta: metav1.ObjectMeta{Name: provisioner},
+			Spec: storagev1.CSIDriverSpec{
+				VolumeLifecycleModes: []storagev1.VolumeLifecycleMode{storagev1.VolumeLifecyclePersistent},
+			},
+		})
+
+		if err := validateBlockDeviceIsPersistentVolumeLifecycle(pvc, csiDriverStore); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("CSIDriver only declares Ephemeral lifecycle: fails", func(t *testing.T) {
+		csiDriverStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		_ = csiDriverStore.Add(&storagev1.CSIDriver{
+			ObjectMeta: metav1.ObjectMeta{Name: provisioner},
+			Spec: storagev1.CSIDriverSpec{
+				VolumeLifecycleModes: []storagev1.VolumeLifecycleMode{storagev1.VolumeLifecycleEphemeral},
+			},
+		})
+
+		if err := validateBlockDeviceIsPersistentVolumeLifecycle(pvc, csiDriverStore); err == nil {
+			t.Fatal("expected an error when the CSI driver doesn't declare the Persistent lifecycle mode")
+		}
+	})
+}
@@ -0,0 +1,130 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1types "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// IsDefaultStorageClassPending determines whether a PVC's StorageClass assignment is
+// still pending a default StorageClass. This is the case when spec.storageClassName is
+// nil (unset), the deprecated beta storage-class annotation isn't set either (a legacy
+// PVC that already resolves to a class via GetPersistentVolumeClaimClass has nothing
+// pending), the PVC is still in the Pending phase, and no default StorageClass currently
+// exists in the cluster, i.e. the PVC
+// is neither statically provisioned (spec.storageClassName == "") nor bound to an
+// explicit class yet.
+func IsDefaultStorageClassPending(pvc *k8sv1.PersistentVolumeClaim, storageClassStore cache.Store) (bool, error) {
+	if pvc.Spec.StorageClassName != nil {
+		return false, nil
+	}
+	if GetPersistentVolumeClaimClass(pvc) != "" {
+		return false, nil
+	}
+	if pvc.Status.Phase != k8sv1.ClaimPending {
+		return false, nil
+	}
+
+	sc, err := getDefaultStorageClass(storageClassStore)
+	if err != nil {
+		return false, err
+	}
+	return sc == nil, nil
+}
+
+// AssignDefaultStorageClassRetroactively mirrors Kubernetes' retroactive default
+// StorageClass assignment: given a PVC whose spec.storageClassName is still nil and a
+// StorageClass store, it returns a strategic-merge patch that sets
+// spec.storageClassName to the name of the current default StorageClass. If no
+// default StorageClass exists, or the PVC is not eligible (spec.storageClassName is
+// already set, the beta storage-class annotation already resolves a class, or the PVC
+// is not Pending), it returns a nil patch and no error — a legacy PVC that already has
+// a class via the annotation must never be overwritten with the cluster default. If
+// more than one StorageClass is marked default, the one with the newest
+// CreationTimestamp is chosen, matching upstream's tie-breaking behavior.
+func AssignDefaultStorageClassRetroactively(pvc *k8sv1.PersistentVolumeClaim, storageClassStore cache.Store) ([]byte, error) {
+	if pvc.Spec.StorageClassName != nil {
+		return nil, nil
+	}
+	if GetPersistentVolumeClaimClass(pvc) != "" {
+		return nil, nil
+	}
+	if pvc.Status.Phase != k8sv1.ClaimPending {
+		return nil, nil
+	}
+
+	defaultSC, err := newestDefaultStorageClass(storageClassStore)
+	if err != nil {
+		return nil, err
+	}
+	if defaultSC == nil {
+		return nil, nil
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"storageClassName":%q}}`, defaultSC.Name)
+	return []byte(patch), nil
+}
+
+// newestDefaultStorageClass returns the StorageClass marked as default via the
+// storageclass.kubernetes.io/is-default-class annotation. When multiple StorageClasses
+// are marked default, the one with the newest CreationTimestamp wins, matching the
+// tie-breaking rule used by the upstream Kubernetes admission controller.
+func newestDefaultStorageClass(storageClassStore cache.Store) (*storagev1.StorageClass, error) {
+	var newest *storagev1.StorageClass
+	for _, obj := range storageClassStore.List() {
+		sc, ok := obj.(*storagev1.StorageClass)
+		if !ok {
+			return nil, fmt.Errorf("failed converting object to a StorageClass: object is of type %T", obj)
+		}
+		if sc.Annotations["storageclass.kubernetes.io/is-default-class"] != "true" {
+			continue
+		}
+		if newest == nil || sc.CreationTimestamp.After(newest.CreationTimestamp.Time) {
+			newest = sc
+		}
+	}
+	return newest, nil
+}
+
+// hasAmbiguousDefaultStorageClass reports whether more than one StorageClass in the
+// store is marked default, which upstream Kubernetes resolves by preferring the newest
+// one but is worth surfacing to users as a likely misconfiguration.
+func hasAmbiguousDefaultStorageClass(storageClassStore cache.Store) (bool, error) {
+	count := 0
+	for _, obj := range storageClassStore.List() {
+		sc, ok := obj.(*storagev1.StorageClass)
+		if !ok {
+			return false, fmt.Errorf("failed converting object to a StorageClass: object is of type %T", obj)
+		}
+		if sc.Annotations["storageclass.kubernetes.io/is-default-class"] == "true" {
+			count++
+		}
+	}
+	return count > 1, nil
+}
+
+// patchType is the strategic-merge patch type used when retroactively assigning a
+// default StorageClass to a PVC.
+const patchType = metav1types.StrategicMergePatchType
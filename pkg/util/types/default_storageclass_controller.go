@@ -0,0 +1,190 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	virtv1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/client-go/log"
+)
+
+// DefaultStorageClassController watches for StorageClass add/update events and, once a
+// default StorageClass becomes available, retroactively patches every Pending PVC that
+// is referenced by a VMI and still awaiting a default StorageClass assignment (i.e.
+// spec.storageClassName is nil). This mirrors the behavior of the upstream
+// pv-protection/default-storage-class admission flow for PVCs that were created before
+// a default StorageClass existed in the cluster.
+type DefaultStorageClassController struct {
+	clientset kubernetes.Interface
+
+	storageClassInformer cache.SharedIndexInformer
+	pvcInformer          cache.SharedIndexInformer
+	vmiInformer          cache.SharedIndexInformer
+
+	queue workqueue.RateLimitingInterface
+
+	recorder record.EventRecorder
+}
+
+// NewDefaultStorageClassController creates a DefaultStorageClassController that keys
+// its work queue off StorageClass events; each queue item is the StorageClass name that
+// triggered the re-evaluation.
+func NewDefaultStorageClassController(
+	clientset kubernetes.Interface,
+	storageClassInformer cache.SharedIndexInformer,
+	pvcInformer cache.SharedIndexInformer,
+	vmiInformer cache.SharedIndexInformer,
+	recorder record.EventRecorder,
+) *DefaultStorageClassController {
+	c := &DefaultStorageClassController{
+		clientset:            clientset,
+		storageClassInformer: storageClassInformer,
+		pvcInformer:          pvcInformer,
+		vmiInformer:          vmiInformer,
+		recorder:             recorder,
+		queue:                workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+
+	c.storageClassInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueStorageClass,
+		UpdateFunc: func(_, new interface{}) { c.enqueueStorageClass(new) },
+	})
+
+	return c
+}
+
+func (c *DefaultStorageClassController) enqueueStorageClass(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run starts the controller's worker loop in the background and blocks until stopCh is
+// closed, at which point the work queue is shut down, unblocking the worker.
+func (c *DefaultStorageClassController) Run(stopCh <-chan struct{}) {
+	defer c.queue.ShutDown()
+
+	log.Log.Info("Starting default StorageClass controller")
+	defer log.Log.Info("Shutting down default StorageClass controller")
+
+	go wait.Until(c.runWorker, time.Second, stopCh)
+
+	<-stopCh
+}
+
+func (c *DefaultStorageClassController) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *DefaultStorageClassController) processNextWorkItem() bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.execute(); err != nil {
+		log.Log.Reason(err).Error("failed to reconcile pending PVCs against default StorageClass")
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// execute re-evaluates every PVC referenced by a VMI across all namespaces, retroactively
+// assigning the current default StorageClass to those still awaiting one. Patching
+// spec.storageClassName causes the PVC informer to deliver an update event, which is
+// what makes downstream WFFC (IsWaitForFirstConsumer) and preallocation (IsPreallocated)
+// checks re-evaluate against the now-resolved StorageClass instead of treating the PVC
+// as pending-default or static.
+func (c *DefaultStorageClassController) execute() error {
+	for _, pvcObj := range c.pvcInformer.GetStore().List() {
+		pvc, ok := pvcObj.(*k8sv1.PersistentVolumeClaim)
+		if !ok {
+			return fmt.Errorf("unexpected object type in PVC store: %T", pvcObj)
+		}
+
+		if !c.isReferencedByVMI(pvc) {
+			continue
+		}
+
+		patch, err := AssignDefaultStorageClassRetroactively(pvc, c.storageClassInformer.GetStore())
+		if err != nil {
+			return err
+		}
+		if patch == nil {
+			continue
+		}
+
+		if vmi := c.lookupReferencingVMI(pvc); vmi != nil {
+			if ambiguous, err := hasAmbiguousDefaultStorageClass(c.storageClassInformer.GetStore()); err == nil && ambiguous {
+				c.recorder.Eventf(vmi, k8sv1.EventTypeWarning, "MultipleDefaultStorageClasses",
+					"more than one default StorageClass found, retroactively assigning the newest one to PVC %s", pvc.Name)
+			}
+		}
+
+		if _, err := c.clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(
+			context.Background(), pvc.Name, patchType, patch, metav1.PatchOptions{},
+		); err != nil {
+			return fmt.Errorf("failed to retroactively patch default StorageClass onto PVC %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// isReferencedByVMI reports whether the given PVC backs at least one volume of a VMI in
+// the same namespace.
+func (c *DefaultStorageClassController) isReferencedByVMI(pvc *k8sv1.PersistentVolumeClaim) bool {
+	return c.lookupReferencingVMI(pvc) != nil
+}
+
+// lookupReferencingVMI returns the first VMI in the PVC's namespace that references it
+// through one of its volumes, or nil if none do.
+func (c *DefaultStorageClassController) lookupReferencingVMI(pvc *k8sv1.PersistentVolumeClaim) *virtv1.VirtualMachineInstance {
+	for _, vmiObj := range c.vmiInformer.GetStore().List() {
+		vmi, ok := vmiObj.(*virtv1.VirtualMachineInstance)
+		if !ok || vmi.Namespace != pvc.Namespace {
+			continue
+		}
+		for _, volume := range vmi.Spec.Volumes {
+			if PVCNameFromVirtVolume(&volume) == pvc.Name {
+				return vmi
+			}
+		}
+	}
+	return nil
+}
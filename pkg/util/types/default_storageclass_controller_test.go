@@ -0,0 +1,53 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDefaultStorageClassControllerRunStopsOnStopChannel(t *testing.T) {
+	c := NewDefaultStorageClassController(
+		fake.NewSimpleClientset(),
+		cache.NewSharedIndexInformer(nil, nil, 0, nil),
+		cache.NewSharedIndexInformer(nil, nil, 0, nil),
+		cache.NewSharedIndexInformer(nil, nil, 0, nil),
+		nil,
+	)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Run(stopCh)
+		close(done)
+	}()
+
+	close(stopCh)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after stopCh was closed; worker loop deadlocked on shutdown")
+	}
+}
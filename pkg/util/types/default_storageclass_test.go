@@ -0,0 +1,236 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"testing"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newStorageClassStore(classes ...*storagev1.StorageClass) cache.Store {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	for _, sc := range classes {
+		_ = store.Add(sc)
+	}
+	return store
+}
+
+func defaultSC(name string, creationTime time.Time) *storagev1.StorageClass {
+	return &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(creationTime),
+			Annotations:       map[string]string{"storageclass.kubernetes.io/is-default-class": "true"},
+		},
+	}
+}
+
+func pendingPVC() *k8sv1.PersistentVolumeClaim {
+	return &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "default"},
+		Spec:       k8sv1.PersistentVolumeClaimSpec{StorageClassName: nil},
+		Status:     k8sv1.PersistentVolumeClaimStatus{Phase: k8sv1.ClaimPending},
+	}
+}
+
+func TestIsDefaultStorageClassPending(t *testing.T) {
+	t.Run("nil storage class name, no default yet: pending", func(t *testing.T) {
+		pending, err := IsDefaultStorageClassPending(pendingPVC(), newStorageClassStore())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !pending {
+			t.Fatal("expected PVC to be pending a default StorageClass")
+		}
+	})
+
+	t.Run("nil storage class name, default exists: not pending", func(t *testing.T) {
+		store := newStorageClassStore(defaultSC("standard", time.Unix(100, 0)))
+		pending, err := IsDefaultStorageClassPending(pendingPVC(), store)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pending {
+			t.Fatal("expected PVC not to be pending once a default StorageClass exists")
+		}
+	})
+
+	t.Run("explicit empty storage class name is statically provisioned, not pending", func(t *testing.T) {
+		pvc := pendingPVC()
+		empty := ""
+		pvc.Spec.StorageClassName = &empty
+
+		if !IsStaticallyProvisioned(pvc) {
+			t.Fatal("expected PVC with explicit empty storageClassName to be statically provisioned")
+		}
+
+		pending, err := IsDefaultStorageClassPending(pvc, newStorageClassStore())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pending {
+			t.Fatal("statically provisioned PVC must never report as pending-default")
+		}
+	})
+
+	t.Run("nil storage class name but resolved via beta annotation: not pending", func(t *testing.T) {
+		pvc := pendingPVC()
+		pvc.Annotations = map[string]string{betaStorageClassAnnotation: "legacy-sc"}
+
+		pending, err := IsDefaultStorageClassPending(pvc, newStorageClassStore())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pending {
+			t.Fatal("a PVC that already resolves a class via the beta annotation must not report as pending-default")
+		}
+	})
+}
+
+func TestAssignDefaultStorageClassRetroactively(t *testing.T) {
+	t.Run("no default StorageClass: no patch", func(t *testing.T) {
+		patch, err := AssignDefaultStorageClassRetroactively(pendingPVC(), newStorageClassStore())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if patch != nil {
+			t.Fatalf("expected no patch, got %s", patch)
+		}
+	})
+
+	t.Run("picks the newest of multiple default StorageClasses", func(t *testing.T) {
+		store := newStorageClassStore(
+			defaultSC("older", time.Unix(100, 0)),
+			defaultSC("newer", time.Unix(200, 0)),
+		)
+
+		patch, err := AssignDefaultStorageClassRetroactively(pendingPVC(), store)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if patch == nil {
+			t.Fatal("expected a patch to be returned")
+		}
+		if got, want := string(patch), `{"spec":{"storageClassName":"newer"}}`; got != want {
+			t.Fatalf("got patch %s, want %s", got, want)
+		}
+	})
+
+	t.Run("explicit storage class name is not eligible for retroactive assignment", func(t *testing.T) {
+		pvc := pendingPVC()
+		name := "manual"
+		pvc.Spec.StorageClassName = &name
+
+		patch, err := AssignDefaultStorageClassRetroactively(pvc, newStorageClassStore(defaultSC("standard", time.Unix(100, 0))))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if patch != nil {
+			t.Fatalf("expected no patch for a PVC with an explicit StorageClass, got %s", patch)
+		}
+	})
+
+	t.Run("legacy PVC resolved via beta annotation is not overwritten with the cluster default", func(t *testing.T) {
+		pvc := pendingPVC()
+		pvc.Annotations = map[string]string{betaStorageClassAnnotation: "legacy-sc"}
+
+		patch, err := AssignDefaultStorageClassRetroactively(pvc, newStorageClassStore(defaultSC("standard", time.Unix(100, 0))))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if patch != nil {
+			t.Fatalf("expected no patch for a PVC already resolved via the beta annotation, got %s", patch)
+		}
+	})
+}
+
+func TestGetPersistentVolumeClaimClassAndGetStorageClassAnnotationHandling(t *testing.T) {
+	className := "real-sc"
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: className}}
+
+	t.Run("GetPersistentVolumeClaimClass prefers the beta annotation over spec.StorageClassName", func(t *testing.T) {
+		specName := "spec-sc"
+		pvc := &k8sv1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{betaStorageClassAnnotation: className}},
+			Spec:       k8sv1.PersistentVolumeClaimSpec{StorageClassName: &specName},
+		}
+		if got := GetPersistentVolumeClaimClass(pvc); got != className {
+			t.Fatalf("got %s, want %s", got, className)
+		}
+	})
+
+	t.Run("GetStorageClass resolves a nil spec.StorageClassName via the beta annotation", func(t *testing.T) {
+		pvc := &k8sv1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{betaStorageClassAnnotation: className}},
+		}
+		got, err := GetStorageClass(pvc, newStorageClassStore(sc))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got == nil || got.Name != className {
+			t.Fatalf("got %v, want StorageClass %s", got, className)
+		}
+	})
+}
+
+func TestIsWaitForFirstConsumer_PendingDefaultIsNotWFFC(t *testing.T) {
+	wffc := storagev1.VolumeBindingWaitForFirstConsumer
+	sc := defaultSC("standard", time.Unix(100, 0))
+	sc.VolumeBindingMode = &wffc
+
+	t.Run("no default yet: false, not an error", func(t *testing.T) {
+		isWFFC, err := IsWaitForFirstConsumer(pendingPVC(), newStorageClassStore())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if isWFFC {
+			t.Fatal("a PVC pending a default StorageClass must not report as WaitForFirstConsumer")
+		}
+	})
+
+	t.Run("default exists and is WFFC: true", func(t *testing.T) {
+		isWFFC, err := IsWaitForFirstConsumer(pendingPVC(), newStorageClassStore(sc))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !isWFFC {
+			t.Fatal("expected PVC to resolve to the WFFC default StorageClass")
+		}
+	})
+
+	t.Run("statically provisioned: always false", func(t *testing.T) {
+		pvc := pendingPVC()
+		empty := ""
+		pvc.Spec.StorageClassName = &empty
+
+		isWFFC, err := IsWaitForFirstConsumer(pvc, newStorageClassStore(sc))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if isWFFC {
+			t.Fatal("a statically provisioned PVC must never report as WaitForFirstConsumer")
+		}
+	})
+}
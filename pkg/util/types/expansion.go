@@ -0,0 +1,123 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// VolumeExpansionPhase describes where a PVC is in the CSI volume expansion state
+// machine, derived from its status conditions. It lets higher-level VMI controllers
+// gate guest-side live-resize operations on the CSI resize having actually completed
+// at the node, rather than merely having been requested.
+type VolumeExpansionPhase string
+
+const (
+	// VolumeExpansionPhasePending indicates no expansion has been requested, or a
+	// requested size above the current capacity has no resize condition set yet.
+	VolumeExpansionPhasePending VolumeExpansionPhase = "Pending"
+	// VolumeExpansionPhasePreparing indicates the controller-side resize (e.g. on the
+	// storage backend) is underway; the condition Resizing is set.
+	VolumeExpansionPhasePreparing VolumeExpansionPhase = "Preparing"
+	// VolumeExpansionPhaseModifying indicates the controller-side resize has completed
+	// and the node is waiting to resize the filesystem; FileSystemResizePending is set.
+	VolumeExpansionPhaseModifying VolumeExpansionPhase = "Modifying"
+	// VolumeExpansionPhaseModified indicates the PVC's capacity has been fully applied,
+	// with no outstanding resize conditions.
+	VolumeExpansionPhaseModified VolumeExpansionPhase = "Modified"
+)
+
+// IsVolumeExpansionSupported determines whether the StorageClass backing the given PVC
+// allows volume expansion. If GetStorageClass returns a nil StorageClass with no error
+// (the PVC is statically provisioned, or has no class set while no default StorageClass
+// exists), expansion support cannot be determined from the StorageClass alone, so an
+// explicit error is returned rather than silently reporting false; callers must treat
+// that as "unknown," not "definitely no."
+func IsVolumeExpansionSupported(pvc *k8sv1.PersistentVolumeClaim, scStore cache.Store) (bool, error) {
+	sc, err := GetStorageClass(pvc, scStore)
+	if err != nil {
+		return false, err
+	}
+	if sc == nil {
+		return false, fmt.Errorf("cannot determine volume expansion support for PVC %s/%s: no StorageClass resolved", pvc.Namespace, pvc.Name)
+	}
+
+	return sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion, nil
+}
+
+// ExpandVirtVolume patches the PVC's requested storage capacity to newSize, triggering
+// a CSI volume expansion. Callers should first confirm IsVolumeExpansionSupported
+// returns true; ExpandVirtVolume does not re-check StorageClass support itself, since
+// the caller already has the PVC and StorageClass in hand by the time it decides to resize.
+func ExpandVirtVolume(clientset kubernetes.Interface, pvc *k8sv1.PersistentVolumeClaim, newSize resource.Quantity) (*k8sv1.PersistentVolumeClaim, error) {
+	updated := pvc.DeepCopy()
+	if updated.Spec.Resources.Requests == nil {
+		updated.Spec.Resources.Requests = k8sv1.ResourceList{}
+	}
+	updated.Spec.Resources.Requests[k8sv1.ResourceStorage] = newSize
+
+	result, err := clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(context.Background(), updated, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand PVC %s/%s to %s: %v", pvc.Namespace, pvc.Name, newSize.String(), err)
+	}
+	return result, nil
+}
+
+// GetVolumeExpansionPhase computes the PVC's current VolumeExpansionPhase from its
+// status conditions: PersistentVolumeClaimResizing ("Resizing") means the
+// controller/storage-backend side of the resize is in progress; once that completes,
+// PersistentVolumeClaimFileSystemResizePending ("FileSystemResizePending") means the
+// node-side filesystem resize is still outstanding. When neither condition is set,
+// status.capacity is compared against the requested size to tell "nothing was ever
+// requested" (VolumeExpansionPhasePending) apart from "a previous resize already
+// completed" (VolumeExpansionPhaseModified).
+func GetVolumeExpansionPhase(pvc *k8sv1.PersistentVolumeClaim) VolumeExpansionPhase {
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Status != k8sv1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case k8sv1.PersistentVolumeClaimResizing:
+			return VolumeExpansionPhasePreparing
+		case k8sv1.PersistentVolumeClaimFileSystemResizePending:
+			return VolumeExpansionPhaseModifying
+		}
+	}
+
+	requested := pvc.Spec.Resources.Requests.Storage()
+	if requested.IsZero() {
+		// Nothing has ever been requested, so there is no resize to have completed.
+		return VolumeExpansionPhasePending
+	}
+
+	actual := pvc.Status.Capacity.Storage()
+	if actual.Cmp(*requested) >= 0 {
+		return VolumeExpansionPhaseModified
+	}
+
+	return VolumeExpansionPhasePending
+}
@@ -0,0 +1,162 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetVolumeExpansionPhase(t *testing.T) {
+	newPVC := func(conditions ...k8sv1.PersistentVolumeClaimCondition) *k8sv1.PersistentVolumeClaim {
+		return &k8sv1.PersistentVolumeClaim{
+			Status: k8sv1.PersistentVolumeClaimStatus{Conditions: conditions},
+		}
+	}
+
+	cases := map[string]struct {
+		pvc  *k8sv1.PersistentVolumeClaim
+		want VolumeExpansionPhase
+	}{
+		"no conditions is pending": {
+			pvc:  newPVC(),
+			want: VolumeExpansionPhasePending,
+		},
+		"resizing condition is preparing": {
+			pvc: newPVC(k8sv1.PersistentVolumeClaimCondition{
+				Type: k8sv1.PersistentVolumeClaimResizing, Status: k8sv1.ConditionTrue,
+			}),
+			want: VolumeExpansionPhasePreparing,
+		},
+		"filesystem resize pending condition is modifying": {
+			pvc: newPVC(k8sv1.PersistentVolumeClaimCondition{
+				Type: k8sv1.PersistentVolumeClaimFileSystemResizePending, Status: k8sv1.ConditionTrue,
+			}),
+			want: VolumeExpansionPhaseModifying,
+		},
+		"false-status conditions are ignored": {
+			pvc: newPVC(k8sv1.PersistentVolumeClaimCondition{
+				Type: k8sv1.PersistentVolumeClaimResizing, Status: k8sv1.ConditionFalse,
+			}),
+			want: VolumeExpansionPhasePending,
+		},
+		"spec ahead of capacity with no conditions is still pending, not modified": {
+			pvc: &k8sv1.PersistentVolumeClaim{
+				Spec: k8sv1.PersistentVolumeClaimSpec{
+					Resources: k8sv1.ResourceRequirements{Requests: k8sv1.ResourceList{
+						k8sv1.ResourceStorage: resource.MustParse("20Gi"),
+					}},
+				},
+				Status: k8sv1.PersistentVolumeClaimStatus{
+					Capacity: k8sv1.ResourceList{k8sv1.ResourceStorage: resource.MustParse("10Gi")},
+				},
+			},
+			want: VolumeExpansionPhasePending,
+		},
+		"capacity meets requested size with no conditions is modified": {
+			pvc: &k8sv1.PersistentVolumeClaim{
+				Spec: k8sv1.PersistentVolumeClaimSpec{
+					Resources: k8sv1.ResourceRequirements{Requests: k8sv1.ResourceList{
+						k8sv1.ResourceStorage: resource.MustParse("10Gi"),
+					}},
+				},
+				Status: k8sv1.PersistentVolumeClaimStatus{
+					Capacity: k8sv1.ResourceList{k8sv1.ResourceStorage: resource.MustParse("10Gi")},
+				},
+			},
+			want: VolumeExpansionPhaseModified,
+		},
+		"capacity exceeds requested size with no conditions is modified": {
+			pvc: &k8sv1.PersistentVolumeClaim{
+				Spec: k8sv1.PersistentVolumeClaimSpec{
+					Resources: k8sv1.ResourceRequirements{Requests: k8sv1.ResourceList{
+						k8sv1.ResourceStorage: resource.MustParse("10Gi"),
+					}},
+				},
+				Status: k8sv1.PersistentVolumeClaimStatus{
+					Capacity: k8sv1.ResourceList{k8sv1.ResourceStorage: resource.MustParse("20Gi")},
+				},
+			},
+			want: VolumeExpansionPhaseModified,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := GetVolumeExpansionPhase(tc.pvc); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestIsVolumeExpansionSupported(t *testing.T) {
+	className := "expandable"
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "default"},
+		Spec:       k8sv1.PersistentVolumeClaimSpec{StorageClassName: &className},
+	}
+
+	t.Run("statically provisioned PVC returns an explicit error, not false", func(t *testing.T) {
+		empty := ""
+		staticPVC := pvc.DeepCopy()
+		staticPVC.Spec.StorageClassName = &empty
+
+		_, err := IsVolumeExpansionSupported(staticPVC, newStorageClassStore())
+		if err == nil {
+			t.Fatal("expected an error for a statically provisioned PVC, got none")
+		}
+	})
+
+	t.Run("allow volume expansion true", func(t *testing.T) {
+		allow := true
+		store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		_ = store.Add(&storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: className},
+			AllowVolumeExpansion: &allow,
+		})
+
+		supported, err := IsVolumeExpansionSupported(pvc, store)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !supported {
+			t.Fatal("expected volume expansion to be supported")
+		}
+	})
+
+	t.Run("allow volume expansion unset is unsupported", func(t *testing.T) {
+		store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		_ = store.Add(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: className}})
+
+		supported, err := IsVolumeExpansionSupported(pvc, store)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if supported {
+			t.Fatal("expected volume expansion to be unsupported")
+		}
+	})
+}
@@ -99,15 +99,30 @@ func VirtVolumesToPVCMap(volumes []*virtv1.Volume, pvcStore cache.Store, namespa
 	return volumeNamesPVCMap, nil
 }
 
-// IsWaitForFirstConsumer determines whether the given PersistentVolumeClaim has a binding mode of WaitForFirstConsumer.
+// IsWaitForFirstConsumer determines whether the given PersistentVolumeClaim has a
+// binding mode of WaitForFirstConsumer. A PVC that is statically provisioned has no
+// binding mode to speak of and is never WFFC. A PVC that is merely pending a default
+// StorageClass assignment (IsDefaultStorageClassPending) is also not WFFC yet: binding
+// decisions are deferred until AssignDefaultStorageClassRetroactively resolves a
+// default and this gets re-evaluated against the real StorageClass.
 func IsWaitForFirstConsumer(pvc *k8sv1.PersistentVolumeClaim, storageClassStore cache.Store) (bool, error) {
-	sc, err := GetStorageClass(pvc, storageClassStore)
+	if IsStaticallyProvisioned(pvc) {
+		return false, nil
+	}
+
+	pending, err := IsDefaultStorageClassPending(pvc, storageClassStore)
 	if err != nil {
 		return false, err
 	}
+	if pending {
+		return false, nil
+	}
 
+	sc, err := GetStorageClass(pvc, storageClassStore)
+	if err != nil {
+		return false, err
+	}
 	if sc == nil {
-		// Statically provisioned volume
 		return false, nil
 	}
 
@@ -115,38 +130,72 @@ func IsWaitForFirstConsumer(pvc *k8sv1.PersistentVolumeClaim, storageClassStore
 	return isWFFC, nil
 }
 
-// IsStaticallyProvisioned determines whether the PersistentVolumeClaim is a statically provisioned volume.
+// IsStaticallyProvisioned determines whether the PersistentVolumeClaim is a statically
+// provisioned volume, i.e. spec.storageClassName is explicitly set to "". A nil
+// storageClassName is NOT statically provisioned: it means the PVC is still awaiting a
+// default StorageClass assignment (see IsDefaultStorageClassPending) and must continue
+// to be treated as dynamic once that default arrives.
 func IsStaticallyProvisioned(pvc *k8sv1.PersistentVolumeClaim) bool {
 	return pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName == ""
 }
 
-// IsDynamicallyProvisioned determines whether the PersistentVolumeClaim is a dynamically provisioned volume.
+// IsDynamicallyProvisioned determines whether the PersistentVolumeClaim is a
+// dynamically provisioned volume. It is the logical negation of
+// IsStaticallyProvisioned: a nil storageClassName is dynamically provisioned
+// (pending-default), not static.
 func IsDynamicallyProvisioned(pvc *k8sv1.PersistentVolumeClaim) bool {
-	return pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != ""
+	return !IsStaticallyProvisioned(pvc)
+}
+
+// betaStorageClassAnnotation is the deprecated annotation some older provisioners and
+// CSI migration paths still use to record a PVC's StorageClass, in place of
+// spec.StorageClassName. It matches k8s.io/api/core/v1.BetaStorageClassAnnotation.
+const betaStorageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+
+// GetPersistentVolumeClaimClass returns the name of the StorageClass associated with
+// the given PersistentVolumeClaim, mirroring upstream Kubernetes'
+// GetPersistentVolumeClaimClass helper: the deprecated beta annotation is preferred
+// over spec.StorageClassName, and an empty string is returned if neither is set.
+func GetPersistentVolumeClaimClass(pvc *k8sv1.PersistentVolumeClaim) string {
+	if class, found := pvc.Annotations[betaStorageClassAnnotation]; found {
+		return class
+	}
+
+	if pvc.Spec.StorageClassName != nil {
+		return *pvc.Spec.StorageClassName
+	}
+
+	return ""
 }
 
 // GetStorageClass returns the StorageClass associated with the given PersistentVolumeClaim,
-// or nil if it's a statically provisioned volume, with no StorageClass associated.
+// or nil if it's a statically provisioned volume, with no StorageClass associated. The
+// beta storage-class annotation is honored before spec.StorageClassName, matching
+// GetPersistentVolumeClaimClass; if neither is set, the cluster's default StorageClass
+// is used instead.
 func GetStorageClass(pvc *k8sv1.PersistentVolumeClaim, storageClassStore cache.Store) (*storagev1.StorageClass, error) {
-	if IsStaticallyProvisioned(pvc) {
-		return nil, nil
+	_, hasBetaAnnotation := pvc.Annotations[betaStorageClassAnnotation]
+	if !hasBetaAnnotation && pvc.Spec.StorageClassName == nil {
+		return getDefaultStorageClass(storageClassStore)
 	}
 
-	if pvc.Spec.StorageClassName == nil {
-		return getDefaultStorageClass(storageClassStore)
+	className := GetPersistentVolumeClaimClass(pvc)
+	if className == "" {
+		// Statically provisioned volume
+		return nil, nil
 	}
 
-	obj, exists, err := storageClassStore.GetByKey(*pvc.Spec.StorageClassName)
+	obj, exists, err := storageClassStore.GetByKey(className)
 	if err != nil {
 		return nil, err
 	}
 	if !exists {
-		return nil, fmt.Errorf("StorageClass %s does not exists", *pvc.Spec.StorageClassName)
+		return nil, fmt.Errorf("StorageClass %s does not exists", className)
 	}
 
 	sc, ok := obj.(*storagev1.StorageClass)
 	if !ok {
-		return nil, fmt.Errorf("failed converting %s to a StorageClass: object is of type %T", *pvc.Spec.StorageClassName, obj)
+		return nil, fmt.Errorf("failed converting %s to a StorageClass: object is of type %T", className, obj)
 	}
 
 	return sc, nil
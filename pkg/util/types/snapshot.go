@@ -0,0 +1,264 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotclient "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+// DefaultSnapshotReadyTimeout is the default amount of time SnapshotVirtVolume waits
+// for a VolumeSnapshot to become ReadyToUse before giving up.
+const DefaultSnapshotReadyTimeout = 5 * time.Minute
+
+// SnapshotVirtVolume creates a VolumeSnapshot for the PVC backing the given VM volume
+// and waits for it to become ReadyToUse. The VolumeSnapshotClass is resolved from the
+// PVC's StorageClass by matching CSI driver names, so callers don't need to author
+// VolumeSnapshot CRs by hand.
+func SnapshotVirtVolume(
+	snapshotClient snapshotclient.Interface,
+	volume *virtv1.Volume,
+	namespace string,
+	pvcStore cache.Store,
+	storageClassStore cache.Store,
+	snapshotClassStore cache.Store,
+	timeout time.Duration,
+) (*snapshotv1.VolumeSnapshot, error) {
+	created, err := createVolumeSnapshot(snapshotClient, volume, namespace, pvcStore, storageClassStore, snapshotClassStore)
+	if err != nil {
+		return nil, err
+	}
+
+	if timeout <= 0 {
+		timeout = DefaultSnapshotReadyTimeout
+	}
+	return waitForSnapshotReady(snapshotClient, namespace, created.Name, timeout)
+}
+
+// createVolumeSnapshot resolves the PVC backing the given VM volume and creates its
+// VolumeSnapshot object, without waiting for it to become ReadyToUse. The CSI snapshot
+// is point-in-time as of this Create call, not as of whenever it later reports ready,
+// which is what lets VirtVolumesToSnapshotMap create every volume's snapshot back to
+// back before waiting on any of them.
+func createVolumeSnapshot(
+	snapshotClient snapshotclient.Interface,
+	volume *virtv1.Volume,
+	namespace string,
+	pvcStore cache.Store,
+	storageClassStore cache.Store,
+	snapshotClassStore cache.Store,
+) (*snapshotv1.VolumeSnapshot, error) {
+	claimName := PVCNameFromVirtVolume(volume)
+	if claimName == "" {
+		return nil, fmt.Errorf("volume %s is not a PVC or DataVolume", volume.Name)
+	}
+
+	pvc, exists, isBlock, err := IsPVCBlockFromStore(pvcStore, namespace, claimName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PVC: %v", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("claim %s not found", claimName)
+	}
+
+	snapshotClassName, err := getVolumeSnapshotClassName(pvc, storageClassStore, snapshotClassStore, isBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &snapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s-snapshot-", pvc.Name),
+			Namespace:    namespace,
+		},
+		Spec: snapshotv1.VolumeSnapshotSpec{
+			Source: snapshotv1.VolumeSnapshotSource{
+				PersistentVolumeClaimName: &pvc.Name,
+			},
+			VolumeSnapshotClassName: &snapshotClassName,
+		},
+	}
+
+	created, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Create(context.Background(), snapshot, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VolumeSnapshot for PVC %s/%s: %v", namespace, pvc.Name, err)
+	}
+	return created, nil
+}
+
+// RestoreVirtVolumeFromSnapshot restores a VolumeSnapshot into a new PersistentVolumeClaim
+// named targetPVCName in targetNamespace. The PVC's DataSource refers to the snapshot by
+// name only, which Kubernetes resolves in the PVC's own namespace; a targetNamespace other
+// than the snapshot's namespace is not handled specially. The restored PVC is
+// ReadWriteOnce and takes its storage class and requested size from storageClassName and
+// restoreSize, leaving requests unset when restoreSize is nil.
+func RestoreVirtVolumeFromSnapshot(
+	clientset kubernetes.Interface,
+	snap *snapshotv1.VolumeSnapshot,
+	targetPVCName string,
+	targetNamespace string,
+	storageClassName *string,
+	restoreSize *k8sv1.ResourceList,
+) (*k8sv1.PersistentVolumeClaim, error) {
+	if snap.Status == nil || snap.Status.ReadyToUse == nil || !*snap.Status.ReadyToUse {
+		return nil, fmt.Errorf("VolumeSnapshot %s/%s is not ready to use", snap.Namespace, snap.Name)
+	}
+
+	apiGroup := "snapshot.storage.k8s.io"
+	restored := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      targetPVCName,
+			Namespace: targetNamespace,
+		},
+		Spec: k8sv1.PersistentVolumeClaimSpec{
+			StorageClassName: storageClassName,
+			DataSource: &k8sv1.TypedLocalObjectReference{
+				APIGroup: &apiGroup,
+				Kind:     "VolumeSnapshot",
+				Name:     snap.Name,
+			},
+			AccessModes: []k8sv1.PersistentVolumeAccessMode{k8sv1.ReadWriteOnce},
+		},
+	}
+	if restoreSize != nil {
+		restored.Spec.Resources = k8sv1.ResourceRequirements{Requests: *restoreSize}
+	}
+
+	return clientset.CoreV1().PersistentVolumeClaims(targetNamespace).Create(context.Background(), restored, metav1.CreateOptions{})
+}
+
+// VirtVolumesToSnapshotMap produces a consistent, point-in-time set of VolumeSnapshots
+// for the given VM volumes, one per volume, keyed by volume name. It mirrors
+// VirtVolumesToPVCMap but snapshots each backing PVC instead of merely resolving it.
+// Every volume's VolumeSnapshot is created in a single back-to-back pass before any of
+// them is waited on, so the point-in-time each CSI driver captures at Create time isn't
+// skewed by how long an earlier volume in the list takes to become ReadyToUse.
+func VirtVolumesToSnapshotMap(
+	snapshotClient snapshotclient.Interface,
+	volumes []*virtv1.Volume,
+	namespace string,
+	pvcStore cache.Store,
+	storageClassStore cache.Store,
+	snapshotClassStore cache.Store,
+	timeout time.Duration,
+) (map[string]*snapshotv1.VolumeSnapshot, error) {
+	created := make(map[string]*snapshotv1.VolumeSnapshot, len(volumes))
+	for _, volume := range volumes {
+		snap, err := createVolumeSnapshot(snapshotClient, volume, namespace, pvcStore, storageClassStore, snapshotClassStore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot volume %s: %v", volume.Name, err)
+		}
+		created[volume.Name] = snap
+	}
+
+	volumeNamesSnapshotMap := make(map[string]*snapshotv1.VolumeSnapshot, len(created))
+	for volumeName, snap := range created {
+		ready, err := waitForSnapshotReady(snapshotClient, namespace, snap.Name, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed waiting for volume %s's snapshot: %v", volumeName, err)
+		}
+		volumeNamesSnapshotMap[volumeName] = ready
+	}
+	return volumeNamesSnapshotMap, nil
+}
+
+// volumeSnapshotClassModeAnnotation optionally marks a VolumeSnapshotClass as intended
+// for a specific PVC volume mode ("Block" or "Filesystem"), for CSI drivers that expose
+// distinct snapshot classes per mode (e.g. because the underlying backend needs
+// different parameters to snapshot a raw block device than a filesystem).
+const volumeSnapshotClassModeAnnotation = "snapshot.storage.kubevirt.io/volume-mode"
+
+// getVolumeSnapshotClassName resolves the VolumeSnapshotClass to use for a PVC by
+// matching its StorageClass's CSI provisioner (driver) name against the driver
+// referenced by each VolumeSnapshotClass in the store. Among classes for that driver, a
+// class annotated for the PVC's actual volume mode (block vs filesystem) is preferred;
+// a class with no such annotation is treated as mode-agnostic and used as a fallback,
+// since most CSI drivers snapshot block and filesystem PVCs identically.
+func getVolumeSnapshotClassName(pvc *k8sv1.PersistentVolumeClaim, storageClassStore cache.Store, snapshotClassStore cache.Store, isBlock bool) (string, error) {
+	sc, err := GetStorageClass(pvc, storageClassStore)
+	if err != nil {
+		return "", err
+	}
+	if sc == nil {
+		return "", fmt.Errorf("cannot resolve a VolumeSnapshotClass for PVC %s/%s: no StorageClass resolved", pvc.Namespace, pvc.Name)
+	}
+
+	wantMode := "Filesystem"
+	if isBlock {
+		wantMode = "Block"
+	}
+
+	fallback := ""
+	for _, obj := range snapshotClassStore.List() {
+		class, ok := obj.(*snapshotv1.VolumeSnapshotClass)
+		if !ok {
+			return "", fmt.Errorf("failed converting object to a VolumeSnapshotClass: object is of type %T", obj)
+		}
+		if class.Driver != sc.Provisioner {
+			continue
+		}
+
+		mode, hasMode := class.Annotations[volumeSnapshotClassModeAnnotation]
+		if hasMode && mode == wantMode {
+			return class.Name, nil
+		}
+		if !hasMode && fallback == "" {
+			fallback = class.Name
+		}
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+
+	mode := "filesystem"
+	if isBlock {
+		mode = "block"
+	}
+	return "", fmt.Errorf("no VolumeSnapshotClass found for CSI driver %s (%s-mode PVC %s/%s)", sc.Provisioner, mode, pvc.Namespace, pvc.Name)
+}
+
+// waitForSnapshotReady polls the given VolumeSnapshot until its status reports
+// ReadyToUse, or returns an error once timeout elapses.
+func waitForSnapshotReady(snapshotClient snapshotclient.Interface, namespace, name string, timeout time.Duration) (*snapshotv1.VolumeSnapshot, error) {
+	var result *snapshotv1.VolumeSnapshot
+	err := wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		snap, err := snapshotClient.SnapshotV1().VolumeSnapshots(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		result = snap
+		return snap.Status != nil && snap.Status.ReadyToUse != nil && *snap.Status.ReadyToUse, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("timed out waiting for VolumeSnapshot %s/%s to become ready: %v", namespace, name, err)
+	}
+	return result, nil
+}
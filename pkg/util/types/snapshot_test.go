@@ -0,0 +1,247 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotfake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	k8sv1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	kubetesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+func TestVirtVolumesToSnapshotMapCreatesAllBeforeWaiting(t *testing.T) {
+	pvcStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+	scStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	snapshotClassStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	_ = scStore.Add(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: "csi-sc"},
+		Provisioner: "csi.example.com",
+	})
+	_ = snapshotClassStore.Add(&snapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "csi-snap-class"},
+		Driver:     "csi.example.com",
+	})
+
+	volumes := []*virtv1.Volume{}
+	for _, name := range []string{"vol-a", "vol-b"} {
+		claimName := name + "-pvc"
+		className := "csi-sc"
+		_ = pvcStore.Add(&k8sv1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: claimName, Namespace: "default"},
+			Spec:       k8sv1.PersistentVolumeClaimSpec{StorageClassName: &className},
+		})
+		volumes = append(volumes, &virtv1.Volume{
+			Name: name,
+			VolumeSource: virtv1.VolumeSource{
+				PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+					PersistentVolumeClaimVolumeSource: k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+				},
+			},
+		})
+	}
+
+	snapshotClient := snapshotfake.NewSimpleClientset()
+
+	var createOrder []string
+	snapshotClient.PrependReactor("create", "volumesnapshots", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		createAction := action.(kubetesting.CreateAction)
+		snap := createAction.GetObject().(*snapshotv1.VolumeSnapshot).DeepCopy()
+		snap.Name = *snap.Spec.Source.PersistentVolumeClaimName + "-snap"
+		createOrder = append(createOrder, snap.Name)
+		return true, snap, nil
+	})
+
+	createsBeforeFirstGet := -1
+	snapshotClient.PrependReactor("get", "volumesnapshots", func(action kubetesting.Action) (bool, runtime.Object, error) {
+		if createsBeforeFirstGet < 0 {
+			createsBeforeFirstGet = len(createOrder)
+		}
+		getAction := action.(kubetesting.GetAction)
+		ready := true
+		return true, &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: getAction.GetName(), Namespace: "default"},
+			Status:     &snapshotv1.VolumeSnapshotStatus{ReadyToUse: &ready},
+		}, nil
+	})
+
+	result, err := VirtVolumesToSnapshotMap(snapshotClient, volumes, "default", pvcStore, scStore, snapshotClassStore, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(result))
+	}
+	if len(createOrder) != 2 {
+		t.Fatalf("expected both volumes' snapshots to be created, got %v", createOrder)
+	}
+	if createsBeforeFirstGet != 2 {
+		t.Fatalf("expected both snapshots to be created before the first readiness check, got %d", createsBeforeFirstGet)
+	}
+
+	if _, err := snapshotClient.SnapshotV1().VolumeSnapshots("default").Get(context.Background(), "vol-a-pvc-snap", metav1.GetOptions{}); err != nil {
+		t.Fatalf("unexpected error fetching snapshot: %v", err)
+	}
+}
+
+func TestGetVolumeSnapshotClassNamePrefersClassMatchingVolumeMode(t *testing.T) {
+	className := "csi-sc"
+	pvc := &k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc", Namespace: "default"},
+		Spec:       k8sv1.PersistentVolumeClaimSpec{StorageClassName: &className},
+	}
+
+	scStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	_ = scStore.Add(&storagev1.StorageClass{
+		ObjectMeta:  metav1.ObjectMeta{Name: className},
+		Provisioner: "csi.example.com",
+	})
+
+	snapshotClassStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	_ = snapshotClassStore.Add(&snapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "csi-block-snap-class",
+			Annotations: map[string]string{volumeSnapshotClassModeAnnotation: "Block"},
+		},
+		Driver: "csi.example.com",
+	})
+	_ = snapshotClassStore.Add(&snapshotv1.VolumeSnapshotClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "csi-fs-snap-class",
+			Annotations: map[string]string{volumeSnapshotClassModeAnnotation: "Filesystem"},
+		},
+		Driver: "csi.example.com",
+	})
+
+	t.Run("block PVC picks the block-annotated class", func(t *testing.T) {
+		name, err := getVolumeSnapshotClassName(pvc, scStore, snapshotClassStore, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "csi-block-snap-class" {
+			t.Fatalf("got %s, want csi-block-snap-class", name)
+		}
+	})
+
+	t.Run("filesystem PVC picks the filesystem-annotated class", func(t *testing.T) {
+		name, err := getVolumeSnapshotClassName(pvc, scStore, snapshotClassStore, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "csi-fs-snap-class" {
+			t.Fatalf("got %s, want csi-fs-snap-class", name)
+		}
+	})
+
+	t.Run("mode-agnostic class is used as a fallback for either mode", func(t *testing.T) {
+		agnosticStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		_ = agnosticStore.Add(&snapshotv1.VolumeSnapshotClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "csi-any-snap-class"},
+			Driver:     "csi.example.com",
+		})
+
+		name, err := getVolumeSnapshotClassName(pvc, scStore, agnosticStore, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if name != "csi-any-snap-class" {
+			t.Fatalf("got %s, want csi-any-snap-class", name)
+		}
+	})
+}
+
+func readyVolumeSnapshot(namespace, name, sourcePVCName string) *snapshotv1.VolumeSnapshot {
+	ready := true
+	return &snapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: snapshotv1.VolumeSnapshotSpec{
+			Source: snapshotv1.VolumeSnapshotSource{PersistentVolumeClaimName: &sourcePVCName},
+		},
+		Status: &snapshotv1.VolumeSnapshotStatus{ReadyToUse: &ready},
+	}
+}
+
+func TestRestoreVirtVolumeFromSnapshot(t *testing.T) {
+	t.Run("not-ready snapshot is rejected", func(t *testing.T) {
+		notReady := false
+		snap := readyVolumeSnapshot("default", "snap", "source-pvc")
+		snap.Status.ReadyToUse = &notReady
+
+		_, err := RestoreVirtVolumeFromSnapshot(fake.NewSimpleClientset(), snap, "restored", "default", nil, nil)
+		if err == nil {
+			t.Fatal("expected an error when restoring from a snapshot that isn't ready to use")
+		}
+	})
+
+	t.Run("snapshot with no status is rejected", func(t *testing.T) {
+		snap := readyVolumeSnapshot("default", "snap", "source-pvc")
+		snap.Status = nil
+
+		_, err := RestoreVirtVolumeFromSnapshot(fake.NewSimpleClientset(), snap, "restored", "default", nil, nil)
+		if err == nil {
+			t.Fatal("expected an error when restoring from a snapshot with no status")
+		}
+	})
+
+	t.Run("ready snapshot is restored into a new PVC pointing at the snapshot as its data source", func(t *testing.T) {
+		snap := readyVolumeSnapshot("default", "snap", "source-pvc")
+		className := "restored-sc"
+		size := k8sv1.ResourceList{k8sv1.ResourceStorage: resource.MustParse("10Gi")}
+
+		clientset := fake.NewSimpleClientset()
+		restored, err := RestoreVirtVolumeFromSnapshot(clientset, snap, "restored-pvc", "other-ns", &className, &size)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if restored.Name != "restored-pvc" || restored.Namespace != "other-ns" {
+			t.Fatalf("got %s/%s, want other-ns/restored-pvc", restored.Namespace, restored.Name)
+		}
+		if restored.Spec.StorageClassName == nil || *restored.Spec.StorageClassName != className {
+			t.Fatalf("got storage class %v, want %s", restored.Spec.StorageClassName, className)
+		}
+		if restored.Spec.DataSource == nil || restored.Spec.DataSource.Kind != "VolumeSnapshot" || restored.Spec.DataSource.Name != snap.Name {
+			t.Fatalf("expected DataSource to reference VolumeSnapshot %s, got %+v", snap.Name, restored.Spec.DataSource)
+		}
+		if got := restored.Spec.Resources.Requests.Storage().String(); got != "10Gi" {
+			t.Fatalf("got requested size %s, want 10Gi", got)
+		}
+
+		fetched, err := clientset.CoreV1().PersistentVolumeClaims("other-ns").Get(context.Background(), "restored-pvc", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected the restored PVC to have actually been created: %v", err)
+		}
+		if fetched.Spec.DataSource.Name != snap.Name {
+			t.Fatalf("got %s, want %s", fetched.Spec.DataSource.Name, snap.Name)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"fmt"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+// topologyZoneLabels are the well-known node labels that carry a PV's zone, in
+// preference order. The deprecated failure-domain.beta label is still emitted by
+// several in-tree and CSI provisioners alongside, or instead of, the stable one.
+var topologyZoneLabels = []string{
+	"topology.kubernetes.io/zone",
+	"failure-domain.beta.kubernetes.io/zone",
+}
+
+// TopologyTerm is a single node-selector requirement derived from a PVC's bound
+// PersistentVolume, e.g. requiring topology.kubernetes.io/zone to be one of the zones
+// the volume was provisioned in.
+type TopologyTerm struct {
+	Key      string
+	Operator k8sv1.NodeSelectorOperator
+	Values   []string
+}
+
+// GetPVCTopologyConstraints returns the required node topology for the given PVC,
+// derived from its bound PersistentVolume: the first well-known zone label set, and any
+// CSI nodeAffinity terms set by the provisioner. A PVC that is not yet bound (e.g.
+// still WaitForFirstConsumer) has no PV to inspect and returns no constraints, since
+// the scheduler is free to pick any zone for it.
+func GetPVCTopologyConstraints(pvc *k8sv1.PersistentVolumeClaim, pvStore cache.Store) ([]TopologyTerm, error) {
+	if pvc.Spec.VolumeName == "" {
+		return nil, nil
+	}
+
+	obj, exists, err := pvStore.GetByKey(pvc.Spec.VolumeName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	pv, ok := obj.(*k8sv1.PersistentVolume)
+	if !ok {
+		return nil, fmt.Errorf("failed converting %s to a PersistentVolume: object is of type %T", pvc.Spec.VolumeName, obj)
+	}
+
+	var terms []TopologyTerm
+	for _, label := range topologyZoneLabels {
+		if zone, found := pv.Labels[label]; found && zone != "" {
+			terms = append(terms, TopologyTerm{
+				Key:      label,
+				Operator: k8sv1.NodeSelectorOpIn,
+				Values:   []string{zone},
+			})
+			break
+		}
+	}
+
+	if pv.Spec.NodeAffinity != nil && pv.Spec.NodeAffinity.Required != nil {
+		for _, selectorTerm := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+			for _, requirement := range selectorTerm.MatchExpressions {
+				terms = append(terms, TopologyTerm{
+					Key:      requirement.Key,
+					Operator: requirement.Operator,
+					Values:   requirement.Values,
+				})
+			}
+		}
+	}
+
+	return terms, nil
+}
+
+// VirtVolumesToTopologyRequirements aggregates the topology constraints of every
+// volume backing a VMI into a single node-selector expression the virt-controller can
+// attach to the virt-launcher pod. When one volume is not yet bound (WFFC) and another
+// already is, the bound volume's constraints take precedence and are surfaced as hard
+// requirements, since the launcher pod must land in whatever zone the bound volume is
+// already provisioned in.
+func VirtVolumesToTopologyRequirements(volumes []*virtv1.Volume, pvcStore cache.Store, pvStore cache.Store, namespace string) ([]TopologyTerm, error) {
+	var terms []TopologyTerm
+	for _, volume := range volumes {
+		claimName := PVCNameFromVirtVolume(volume)
+		if claimName == "" {
+			return nil, fmt.Errorf("volume %s is not a PVC or DataVolume", volume.Name)
+		}
+
+		pvc, exists, _, err := IsPVCBlockFromStore(pvcStore, namespace, claimName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get PVC: %v", err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("claim %s not found", claimName)
+		}
+
+		volumeTerms, err := GetPVCTopologyConstraints(pvc, pvStore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine topology constraints for volume %s: %v", volume.Name, err)
+		}
+		terms = append(terms, volumeTerms...)
+	}
+
+	return dedupeTopologyTerms(terms)
+}
+
+// dedupeTopologyTerms merges TopologyTerms that share a Key by intersecting their
+// Values, so that if two volumes independently require the same zone label, the
+// aggregated result carries a single, hard requirement instead of duplicate terms.
+// Terms sharing a Key must also agree on Operator: two different operators on the same
+// key (e.g. In vs. NotIn) cannot be merged into one requirement and are reported as a
+// conflict. Exists/DoesNotExist requirements carry no Values to intersect, so the same
+// operator on both sides is already the full, identical requirement. Otherwise, if two
+// volumes require disjoint values for the same key (e.g. one PV pinned to zone A,
+// another to zone B), that placement is genuinely unsatisfiable by any single node and
+// is reported as an error rather than silently dropping one volume's constraint.
+func dedupeTopologyTerms(terms []TopologyTerm) ([]TopologyTerm, error) {
+	byKey := make(map[string]*TopologyTerm)
+	var order []string
+
+	for _, term := range terms {
+		existing, found := byKey[term.Key]
+		if !found {
+			t := term
+			byKey[term.Key] = &t
+			order = append(order, term.Key)
+			continue
+		}
+
+		if existing.Operator != term.Operator {
+			return nil, fmt.Errorf("conflicting topology requirements for %s: operator %s is incompatible with %s", term.Key, existing.Operator, term.Operator)
+		}
+		if len(existing.Values) == 0 && len(term.Values) == 0 {
+			continue
+		}
+
+		merged, ok := intersect(existing.Values, term.Values)
+		if !ok {
+			return nil, fmt.Errorf("conflicting topology requirements for %s: %v is incompatible with %v", term.Key, existing.Values, term.Values)
+		}
+		existing.Values = merged
+	}
+
+	result := make([]TopologyTerm, 0, len(order))
+	for _, key := range order {
+		result = append(result, *byKey[key])
+	}
+	return result, nil
+}
+
+// intersect returns the values common to both a and b, and false if they share none
+// (meaning the two requirements are mutually exclusive and cannot both be satisfied).
+func intersect(a, b []string) ([]string, bool) {
+	set := make(map[string]bool, len(a))
+	for _, v := range a {
+		set[v] = true
+	}
+
+	var result []string
+	for _, v := range b {
+		if set[v] {
+			result = append(result, v)
+		}
+	}
+	return result, len(result) > 0
+}
@@ -0,0 +1,182 @@
+/*
+ * This file is part of the KubeVirt project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2022 Red Hat, Inc.
+ *
+ */
+
+package types
+
+import (
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+
+	virtv1 "kubevirt.io/client-go/api/v1"
+)
+
+func TestDedupeTopologyTermsMergesCompatibleRequirements(t *testing.T) {
+	terms := []TopologyTerm{
+		{Key: "topology.kubernetes.io/zone", Operator: k8sv1.NodeSelectorOpIn, Values: []string{"zone-a", "zone-b"}},
+		{Key: "topology.kubernetes.io/zone", Operator: k8sv1.NodeSelectorOpIn, Values: []string{"zone-b"}},
+	}
+
+	result, err := dedupeTopologyTerms(terms)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 1 || len(result[0].Values) != 1 || result[0].Values[0] != "zone-b" {
+		t.Fatalf("expected a single merged term with zone-b, got %+v", result)
+	}
+}
+
+func TestDedupeTopologyTermsRejectsDisjointRequirements(t *testing.T) {
+	terms := []TopologyTerm{
+		{Key: "topology.kubernetes.io/zone", Operator: k8sv1.NodeSelectorOpIn, Values: []string{"zone-a"}},
+		{Key: "topology.kubernetes.io/zone", Operator: k8sv1.NodeSelectorOpIn, Values: []string{"zone-b"}},
+	}
+
+	_, err := dedupeTopologyTerms(terms)
+	if err == nil {
+		t.Fatal("expected an error for mutually exclusive zone requirements, got none")
+	}
+}
+
+func TestDedupeTopologyTermsRejectsConflictingOperators(t *testing.T) {
+	terms := []TopologyTerm{
+		{Key: "topology.kubernetes.io/zone", Operator: k8sv1.NodeSelectorOpIn, Values: []string{"zone-a"}},
+		{Key: "topology.kubernetes.io/zone", Operator: k8sv1.NodeSelectorOpNotIn, Values: []string{"zone-a"}},
+	}
+
+	_, err := dedupeTopologyTerms(terms)
+	if err == nil {
+		t.Fatal("expected an error for conflicting operators on the same key, got none")
+	}
+}
+
+func TestDedupeTopologyTermsMergesIdenticalValuelessOperators(t *testing.T) {
+	terms := []TopologyTerm{
+		{Key: "topology.kubernetes.io/region", Operator: k8sv1.NodeSelectorOpExists},
+		{Key: "topology.kubernetes.io/region", Operator: k8sv1.NodeSelectorOpExists},
+	}
+
+	result, err := dedupeTopologyTerms(terms)
+	if err != nil {
+		t.Fatalf("unexpected error for two identical Exists requirements: %v", err)
+	}
+	if len(result) != 1 || result[0].Operator != k8sv1.NodeSelectorOpExists || len(result[0].Values) != 0 {
+		t.Fatalf("expected a single merged Exists term, got %+v", result)
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	t.Run("overlapping values", func(t *testing.T) {
+		result, ok := intersect([]string{"a", "b"}, []string{"b", "c"})
+		if !ok {
+			t.Fatal("expected overlapping values to be compatible")
+		}
+		if len(result) != 1 || result[0] != "b" {
+			t.Fatalf("got %v, want [b]", result)
+		}
+	})
+
+	t.Run("disjoint values", func(t *testing.T) {
+		_, ok := intersect([]string{"a"}, []string{"b"})
+		if ok {
+			t.Fatal("expected disjoint values to be reported as incompatible")
+		}
+	})
+}
+
+func newBoundPV(name, zone string) *k8sv1.PersistentVolume {
+	return &k8sv1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"topology.kubernetes.io/zone": zone},
+		},
+	}
+}
+
+func TestGetPVCTopologyConstraints(t *testing.T) {
+	t.Run("unbound PVC has no PV to inspect: no constraints", func(t *testing.T) {
+		pvc := &k8sv1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc"}}
+
+		terms, err := GetPVCTopologyConstraints(pvc, cache.NewStore(cache.MetaNamespaceKeyFunc))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if terms != nil {
+			t.Fatalf("expected no constraints for an unbound PVC, got %+v", terms)
+		}
+	})
+
+	t.Run("bound PVC inherits its PV's zone label", func(t *testing.T) {
+		pvc := &k8sv1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "pvc"},
+			Spec:       k8sv1.PersistentVolumeClaimSpec{VolumeName: "pv-a"},
+		}
+		pvStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		_ = pvStore.Add(newBoundPV("pv-a", "zone-a"))
+
+		terms, err := GetPVCTopologyConstraints(pvc, pvStore)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(terms) != 1 || terms[0].Values[0] != "zone-a" {
+			t.Fatalf("expected a single zone-a constraint, got %+v", terms)
+		}
+	})
+}
+
+func newTopologyVolume(name, claimName string) *virtv1.Volume {
+	return &virtv1.Volume{
+		Name: name,
+		VolumeSource: virtv1.VolumeSource{
+			PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+				PersistentVolumeClaimVolumeSource: k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+			},
+		},
+	}
+}
+
+func TestVirtVolumesToTopologyRequirementsBoundTakesPrecedenceOverWFFC(t *testing.T) {
+	pvcStore := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+	pvStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	_ = pvcStore.Add(&k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "bound-pvc", Namespace: "default"},
+		Spec:       k8sv1.PersistentVolumeClaimSpec{VolumeName: "pv-a"},
+	})
+	_ = pvStore.Add(newBoundPV("pv-a", "zone-a"))
+
+	_ = pvcStore.Add(&k8sv1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "wffc-pvc", Namespace: "default"},
+	})
+
+	volumes := []*virtv1.Volume{
+		newTopologyVolume("bound-vol", "bound-pvc"),
+		newTopologyVolume("wffc-vol", "wffc-pvc"),
+	}
+
+	terms, err := VirtVolumesToTopologyRequirements(volumes, pvcStore, pvStore, "default")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(terms) != 1 || terms[0].Values[0] != "zone-a" {
+		t.Fatalf("expected the bound volume's zone-a constraint to be the only requirement, got %+v", terms)
+	}
+}